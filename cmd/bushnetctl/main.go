@@ -0,0 +1,135 @@
+// Command bushnetctl is a small CLI client for bushnet-server's control
+// API, in the spirit of itctl talking to itd's IPC socket.
+//
+// Usage:
+//
+//	bushnetctl [-addr http://localhost:2040] status
+//	bushnetctl [-addr http://localhost:2040] sync <device>
+//	bushnetctl [-addr http://localhost:2040] blacklist <device>
+//	bushnetctl [-addr http://localhost:2040] whitelist <device>
+//	bushnetctl [-addr http://localhost:2040] led <on|off|blinking>
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+)
+
+type deviceStatus struct {
+	Name          string `json:"name"`
+	Address       string `json:"address"`
+	LastSeen      string `json:"lastSeen"`
+	InFlight      bool   `json:"inFlight"`
+	ErrorCount    int    `json:"errorCount"`
+	LastError     string `json:"lastError,omitempty"`
+	DownloadCount int    `json:"downloadCount"`
+	Blacklisted   bool   `json:"blacklisted"`
+}
+
+func main() {
+	log.SetFlags(0)
+
+	addr := flag.String("addr", "http://localhost:2040", "address of the bushnet-server control API")
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: bushnetctl [-addr url] <status|sync|blacklist|whitelist|led> [arg]")
+		os.Exit(1)
+	}
+
+	var err error
+	switch args[0] {
+	case "status":
+		err = status(*addr)
+	case "sync":
+		err = requireArg(args, "device name", func(name string) error { return sync(*addr, name) })
+	case "blacklist":
+		err = requireArg(args, "device name", func(name string) error { return blacklist(*addr, name, true) })
+	case "whitelist":
+		err = requireArg(args, "device name", func(name string) error { return blacklist(*addr, name, false) })
+	case "led":
+		err = requireArg(args, "LED state", func(state string) error { return led(*addr, state) })
+	default:
+		err = fmt.Errorf("unknown command %q", args[0])
+	}
+
+	if err != nil {
+		log.Fatalf("bushnetctl: %v", err)
+	}
+}
+
+func requireArg(args []string, what string, fn func(string) error) error {
+	if len(args) < 2 {
+		return fmt.Errorf("missing %s", what)
+	}
+	return fn(args[1])
+}
+
+func status(addr string) error {
+	resp, err := http.Get(addr + "/api/devices")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return err
+	}
+
+	var devices []deviceStatus
+	if err := json.NewDecoder(resp.Body).Decode(&devices); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	for _, d := range devices {
+		fmt.Printf("%-20s %-15s inFlight=%-5v errors=%-3d downloads=%-4d blacklisted=%v\n",
+			d.Name, d.Address, d.InFlight, d.ErrorCount, d.DownloadCount, d.Blacklisted)
+		if d.LastError != "" {
+			fmt.Printf("  last error: %s\n", d.LastError)
+		}
+	}
+	return nil
+}
+
+func sync(addr, device string) error {
+	return postJSON(addr+"/api/sync", map[string]string{"device": device})
+}
+
+func blacklist(addr, device string, blacklisted bool) error {
+	return postJSON(addr+"/api/devices/blacklist", map[string]interface{}{
+		"device":      device,
+		"blacklisted": blacklisted,
+	})
+}
+
+func led(addr, state string) error {
+	return postJSON(addr+"/api/led", map[string]string{"state": state})
+}
+
+func postJSON(url string, body interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkStatus(resp)
+}
+
+func checkStatus(resp *http.Response) error {
+	if resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, body)
+	}
+	return nil
+}
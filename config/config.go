@@ -0,0 +1,215 @@
+// Package config loads bushnet-server settings from a TOML file with
+// environment-variable and command-line-flag overrides, the pattern common
+// to Go daemons where every config key is also a flag. This lets operators
+// on non-Raspberry-Pi hosts, or with custom spool locations, run without
+// recompiling.
+//
+// Precedence, highest first: command-line flag, environment variable,
+// config file, built-in default.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+const (
+	DefaultPath = "/etc/bushnet-server/config.toml"
+
+	defaultCPTVFolder     = "/var/spool/cptv/downloaded"
+	defaultServiceType    = "_cacophonator-management._tcp"
+	defaultLEDTriggerFile = "/sys/class/leds/led0/trigger"
+	defaultPollInterval   = 10 * time.Second
+	defaultHTTPTimeout    = 30 * time.Second
+	defaultConcurrency    = 4
+	defaultRateLimit      = 0
+	defaultControlAddr    = "" // empty disables the control API
+	defaultMetricsAddr    = "" // empty disables the /metrics endpoint
+)
+
+// DeviceCredentials holds optional per-device basic-auth credentials, keyed
+// by device name in the [devices.<name>] table of the config file.
+type DeviceCredentials struct {
+	Username string `toml:"username"`
+	Password string `toml:"password"`
+}
+
+// WebhookSinkConfig configures the webhook notification sink.
+type WebhookSinkConfig struct {
+	Enabled bool   `toml:"enabled"`
+	URL     string `toml:"url"`
+}
+
+// KafkaSinkConfig configures the Kafka notification sink.
+type KafkaSinkConfig struct {
+	Enabled bool     `toml:"enabled"`
+	Brokers []string `toml:"brokers"`
+	Topic   string   `toml:"topic"`
+}
+
+// S3SinkConfig configures the S3/MinIO mirroring sink.
+type S3SinkConfig struct {
+	Enabled     bool   `toml:"enabled"`
+	Endpoint    string `toml:"endpoint"`
+	Bucket      string `toml:"bucket"`
+	AccessKey   string `toml:"access_key"`
+	SecretKey   string `toml:"secret_key"`
+	UseSSL      bool   `toml:"use_ssl"`
+	DeleteLocal bool   `toml:"delete_local"`
+}
+
+// SinksConfig groups the settings for every notification/upload sink.
+type SinksConfig struct {
+	Webhook WebhookSinkConfig `toml:"webhook"`
+	Kafka   KafkaSinkConfig   `toml:"kafka"`
+	S3      S3SinkConfig      `toml:"s3"`
+}
+
+// Config holds every setting that was previously a hard-coded const.
+type Config struct {
+	CPTVFolder     string                       `toml:"cptv_folder"`
+	ServiceType    string                       `toml:"service_type"`
+	LEDTriggerFile string                       `toml:"led_trigger_file"`
+	PollInterval   time.Duration                `toml:"poll_interval"`
+	HTTPTimeout    time.Duration                `toml:"http_timeout"`
+	Concurrency    int                          `toml:"concurrency"`
+	RateLimit      int                          `toml:"rate_limit"`
+	Devices        map[string]DeviceCredentials `toml:"devices"`
+	Sinks          SinksConfig                  `toml:"sinks"`
+	ControlAddr    string                       `toml:"control_addr"`
+	MetricsAddr    string                       `toml:"metrics_addr"`
+}
+
+// Default returns the built-in defaults, matching the values that used to
+// be package-level consts.
+func Default() *Config {
+	return &Config{
+		CPTVFolder:     defaultCPTVFolder,
+		ServiceType:    defaultServiceType,
+		LEDTriggerFile: defaultLEDTriggerFile,
+		PollInterval:   defaultPollInterval,
+		HTTPTimeout:    defaultHTTPTimeout,
+		Concurrency:    defaultConcurrency,
+		RateLimit:      defaultRateLimit,
+		Devices:        map[string]DeviceCredentials{},
+		ControlAddr:    defaultControlAddr,
+		MetricsAddr:    defaultMetricsAddr,
+	}
+}
+
+// Load reads path on top of Default, then applies environment variable
+// overrides. It returns the resulting Config plus a list of human-readable
+// warnings for unknown keys found in the file. A missing file is not an
+// error; it just means the defaults (and any env overrides) apply.
+func Load(path string) (*Config, []string, error) {
+	cfg := Default()
+
+	var warnings []string
+	if _, err := os.Stat(path); err == nil {
+		meta, err := toml.DecodeFile(path, cfg)
+		if err != nil {
+			return nil, nil, fmt.Errorf("config: failed to parse %s: %w", path, err)
+		}
+		for _, key := range meta.Undecoded() {
+			warnings = append(warnings, fmt.Sprintf("unknown config key %q in %s", key, path))
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, nil, fmt.Errorf("config: failed to stat %s: %w", path, err)
+	}
+
+	applyEnv(cfg)
+
+	return cfg, warnings, nil
+}
+
+func applyEnv(cfg *Config) {
+	if v, ok := os.LookupEnv("BUSHNET_CPTV_FOLDER"); ok {
+		cfg.CPTVFolder = v
+	}
+	if v, ok := os.LookupEnv("BUSHNET_SERVICE_TYPE"); ok {
+		cfg.ServiceType = v
+	}
+	if v, ok := os.LookupEnv("BUSHNET_LED_TRIGGER_FILE"); ok {
+		cfg.LEDTriggerFile = v
+	}
+	if v, ok := os.LookupEnv("BUSHNET_POLL_INTERVAL"); ok {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.PollInterval = d
+		}
+	}
+	if v, ok := os.LookupEnv("BUSHNET_HTTP_TIMEOUT"); ok {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.HTTPTimeout = d
+		}
+	}
+	if v, ok := os.LookupEnv("BUSHNET_CONCURRENCY"); ok {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.Concurrency = n
+		}
+	}
+	if v, ok := os.LookupEnv("BUSHNET_RATE_LIMIT"); ok {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.RateLimit = n
+		}
+	}
+	if v, ok := os.LookupEnv("BUSHNET_CONTROL_ADDR"); ok {
+		cfg.ControlAddr = v
+	}
+	if v, ok := os.LookupEnv("BUSHNET_METRICS_ADDR"); ok {
+		cfg.MetricsAddr = v
+	}
+}
+
+// RegisterFlags adds a flag for every setting to fs, seeded with cfg's
+// current values (i.e. the result of Load). Flags parsed afterwards take
+// precedence over the file and environment.
+func RegisterFlags(fs *flag.FlagSet, cfg *Config) {
+	fs.StringVar(&cfg.CPTVFolder, "cptv-folder", cfg.CPTVFolder, "folder to save downloaded recordings into")
+	fs.StringVar(&cfg.ServiceType, "service-type", cfg.ServiceType, "mDNS service type to browse for")
+	fs.StringVar(&cfg.LEDTriggerFile, "led-trigger-file", cfg.LEDTriggerFile, "sysfs trigger file for the status LED")
+	fs.DurationVar(&cfg.PollInterval, "poll-interval", cfg.PollInterval, "how often to re-browse for devices")
+	fs.DurationVar(&cfg.HTTPTimeout, "http-timeout", cfg.HTTPTimeout, "timeout for HTTP requests to devices")
+	fs.IntVar(&cfg.Concurrency, "concurrency", cfg.Concurrency, "number of devices to download from at once")
+	fs.IntVar(&cfg.RateLimit, "rate-limit", cfg.RateLimit, "per-device download budget in bytes/sec (0 for unlimited)")
+	fs.StringVar(&cfg.ControlAddr, "control-addr", cfg.ControlAddr, "address to serve the control API on, e.g. :2040 (empty disables it)")
+	fs.StringVar(&cfg.MetricsAddr, "metrics-addr", cfg.MetricsAddr, "address to serve Prometheus /metrics on, e.g. :2041 (empty disables it)")
+}
+
+// Validate checks that the config is usable, creating CPTVFolder if it does
+// not already exist.
+func (c *Config) Validate() error {
+	if c.Concurrency <= 0 {
+		return fmt.Errorf("config: concurrency must be positive, got %d", c.Concurrency)
+	}
+	if c.RateLimit < 0 {
+		return fmt.Errorf("config: rate_limit must not be negative, got %d", c.RateLimit)
+	}
+	if c.CPTVFolder == "" {
+		return fmt.Errorf("config: cptv_folder must not be empty")
+	}
+	if err := os.MkdirAll(c.CPTVFolder, 0755); err != nil {
+		return fmt.Errorf("config: cptv_folder %q is not usable: %w", c.CPTVFolder, err)
+	}
+	return nil
+}
+
+// WatchReload calls onReload with a freshly loaded Config, built from path
+// and the environment, every time the process receives SIGHUP. Flag
+// overrides from the original invocation are not re-applied on reload.
+func WatchReload(path string, onReload func(*Config, []string, error)) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	go func() {
+		for range sighup {
+			cfg, warnings, err := Load(path)
+			onReload(cfg, warnings, err)
+		}
+	}()
+}
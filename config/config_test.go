@@ -0,0 +1,134 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTemp(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadMissingFileUsesDefaults(t *testing.T) {
+	cfg, warnings, err := Load(filepath.Join(t.TempDir(), "does-not-exist.toml"))
+	if err != nil {
+		t.Fatalf("Load returned error for a missing file: %v", err)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("expected no warnings for a missing file, got %v", warnings)
+	}
+	if cfg.Concurrency != defaultConcurrency {
+		t.Errorf("Concurrency = %d, want default %d", cfg.Concurrency, defaultConcurrency)
+	}
+}
+
+func TestLoadFileOverridesDefaults(t *testing.T) {
+	path := writeTemp(t, `
+concurrency = 8
+rate_limit = 1024
+`)
+	cfg, _, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Concurrency != 8 {
+		t.Errorf("Concurrency = %d, want 8", cfg.Concurrency)
+	}
+	if cfg.RateLimit != 1024 {
+		t.Errorf("RateLimit = %d, want 1024", cfg.RateLimit)
+	}
+	if cfg.CPTVFolder != defaultCPTVFolder {
+		t.Errorf("CPTVFolder = %q, want untouched default %q", cfg.CPTVFolder, defaultCPTVFolder)
+	}
+}
+
+func TestLoadUnknownKeyWarns(t *testing.T) {
+	path := writeTemp(t, `
+concurrency = 2
+not_a_real_key = "oops"
+`)
+	_, warnings, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(warnings) != 1 {
+		t.Fatalf("warnings = %v, want exactly one", warnings)
+	}
+}
+
+func TestApplyEnvOverridesFileAndDefaults(t *testing.T) {
+	path := writeTemp(t, `concurrency = 8`)
+
+	t.Setenv("BUSHNET_CONCURRENCY", "16")
+	t.Setenv("BUSHNET_RATE_LIMIT", "2048")
+	t.Setenv("BUSHNET_POLL_INTERVAL", "1m")
+
+	cfg, _, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Concurrency != 16 {
+		t.Errorf("Concurrency = %d, want env override 16", cfg.Concurrency)
+	}
+	if cfg.RateLimit != 2048 {
+		t.Errorf("RateLimit = %d, want env override 2048", cfg.RateLimit)
+	}
+	if cfg.PollInterval != time.Minute {
+		t.Errorf("PollInterval = %v, want env override 1m", cfg.PollInterval)
+	}
+}
+
+func TestApplyEnvIgnoresUnparsableValues(t *testing.T) {
+	cfg := Default()
+	t.Setenv("BUSHNET_CONCURRENCY", "not-a-number")
+	applyEnv(cfg)
+	if cfg.Concurrency != defaultConcurrency {
+		t.Errorf("Concurrency = %d, want unchanged default %d after unparsable env override", cfg.Concurrency, defaultConcurrency)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		mutate  func(*Config)
+		wantErr bool
+	}{
+		{"valid defaults", func(*Config) {}, false},
+		{"zero concurrency", func(c *Config) { c.Concurrency = 0 }, true},
+		{"negative concurrency", func(c *Config) { c.Concurrency = -1 }, true},
+		{"negative rate limit", func(c *Config) { c.RateLimit = -1 }, true},
+		{"empty cptv folder", func(c *Config) { c.CPTVFolder = "" }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			cfg.CPTVFolder = filepath.Join(t.TempDir(), "cptv")
+			tt.mutate(cfg)
+			err := cfg.Validate()
+			if tt.wantErr && err == nil {
+				t.Error("Validate() = nil, want error")
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("Validate() = %v, want nil", err)
+			}
+		})
+	}
+}
+
+func TestValidateCreatesCPTVFolder(t *testing.T) {
+	cfg := Default()
+	cfg.CPTVFolder = filepath.Join(t.TempDir(), "nested", "cptv")
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if info, err := os.Stat(cfg.CPTVFolder); err != nil || !info.IsDir() {
+		t.Errorf("cptv_folder %q was not created as a directory", cfg.CPTVFolder)
+	}
+}
@@ -0,0 +1,141 @@
+// Package control exposes an HTTP+JSON API for observing and driving a
+// running bushnet-server: listing discovered devices and their state,
+// triggering an on-demand sync, blacklisting/whitelisting a device by
+// name, and setting the status LED manually. It turns the daemon from a
+// black box into something an operator can query and poke, in the way
+// itd exposes an IPC socket for itctl. The companion cmd/bushnetctl
+// binary is a small CLI client for this API.
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// DeviceStatus is what the status endpoint reports for a single device.
+type DeviceStatus struct {
+	Name          string    `json:"name"`
+	Address       string    `json:"address"`
+	LastSeen      time.Time `json:"lastSeen"`
+	InFlight      bool      `json:"inFlight"`
+	ErrorCount    int       `json:"errorCount"`
+	LastError     string    `json:"lastError,omitempty"`
+	DownloadCount int       `json:"downloadCount"`
+	Blacklisted   bool      `json:"blacklisted"`
+}
+
+// Server implements the control API on top of callbacks into main's
+// running state. It does not own any state itself.
+type Server struct {
+	// Status returns the current state of every known device.
+	Status func() []DeviceStatus
+	// Sync triggers an on-demand download cycle for the named device.
+	Sync func(name string) error
+	// SetBlacklisted blacklists or whitelists the named device.
+	SetBlacklisted func(name string, blacklisted bool) error
+	// SetLED sets the status LED to the given state ("on", "off",
+	// "blinking").
+	SetLED func(state string) error
+}
+
+// Handler returns an http.Handler serving the control API.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/devices", s.handleDevices)
+	mux.HandleFunc("/api/sync", s.handleSync)
+	mux.HandleFunc("/api/devices/blacklist", s.handleBlacklist)
+	mux.HandleFunc("/api/led", s.handleLED)
+	return mux
+}
+
+func (s *Server) handleDevices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.Status())
+}
+
+type syncRequest struct {
+	Device string `json:"device"`
+}
+
+func (s *Server) handleSync(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req syncRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Device == "" {
+		http.Error(w, "missing or invalid \"device\"", http.StatusBadRequest)
+		return
+	}
+	if err := s.Sync(req.Device); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+type blacklistRequest struct {
+	Device      string `json:"device"`
+	Blacklisted bool   `json:"blacklisted"`
+}
+
+func (s *Server) handleBlacklist(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req blacklistRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Device == "" {
+		http.Error(w, "missing or invalid \"device\"", http.StatusBadRequest)
+		return
+	}
+	if err := s.SetBlacklisted(req.Device, req.Blacklisted); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+type ledRequest struct {
+	State string `json:"state"`
+}
+
+func (s *Server) handleLED(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req ledRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.State == "" {
+		http.Error(w, "missing or invalid \"state\"", http.StatusBadRequest)
+		return
+	}
+	if err := s.SetLED(req.State); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// ErrNotFound is returned by Sync and SetBlacklisted callbacks for an
+// unknown device name.
+var ErrNotFound = errors.New("device not found")
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	if errors.Is(err, ErrNotFound) {
+		status = http.StatusNotFound
+	}
+	http.Error(w, err.Error(), status)
+}
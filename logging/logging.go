@@ -0,0 +1,131 @@
+// Package logging provides small leveled loggers scoped to a subsystem
+// (discovery, http, led, download, ...), in the spirit of syncthing's
+// STTRACE. Debug output is silent by default and can be switched on per
+// subsystem with the BUSHNET_TRACE environment variable, e.g.
+//
+//	BUSHNET_TRACE=discovery,download ./bushnet-server
+//	BUSHNET_TRACE=all ./bushnet-server
+//
+// Set BUSHNET_LOG_FORMAT=json to emit one JSON object per line instead of
+// plain text, for ingestion by log aggregators.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log entry.
+type Level int
+
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+func (l Level) String() string {
+	switch l {
+	case Debug:
+		return "debug"
+	case Info:
+		return "info"
+	case Warn:
+		return "warn"
+	case Error:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+var (
+	mu         sync.Mutex
+	jsonOutput = os.Getenv("BUSHNET_LOG_FORMAT") == "json"
+	traced     = parseTrace(os.Getenv("BUSHNET_TRACE"))
+)
+
+func parseTrace(v string) map[string]bool {
+	m := make(map[string]bool)
+	for _, s := range strings.Split(v, ",") {
+		s = strings.TrimSpace(s)
+		if s != "" {
+			m[s] = true
+		}
+	}
+	return m
+}
+
+// Logger writes leveled, subsystem-tagged messages.
+type Logger struct {
+	subsystem string
+}
+
+// New returns a Logger for the given subsystem, e.g. "discovery" or "http".
+func New(subsystem string) *Logger {
+	return &Logger{subsystem: subsystem}
+}
+
+// traceEnabled reports whether debug output is enabled for this logger's
+// subsystem, either directly or via BUSHNET_TRACE=all.
+func (l *Logger) traceEnabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return traced["all"] || traced[l.subsystem]
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	if !l.traceEnabled() {
+		return
+	}
+	l.log(Debug, format, args...)
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.log(Info, format, args...)
+}
+
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.log(Warn, format, args...)
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.log(Error, format, args...)
+}
+
+type jsonEntry struct {
+	Time      string `json:"time"`
+	Level     string `json:"level"`
+	Subsystem string `json:"subsystem"`
+	Message   string `json:"message"`
+}
+
+func (l *Logger) log(level Level, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if jsonOutput {
+		entry := jsonEntry{
+			Time:      time.Now().UTC().Format(time.RFC3339Nano),
+			Level:     level.String(),
+			Subsystem: l.subsystem,
+			Message:   msg,
+		}
+		b, err := json.Marshal(entry)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "logging: failed to marshal entry: %v\n", err)
+			return
+		}
+		fmt.Fprintln(os.Stdout, string(b))
+		return
+	}
+
+	fmt.Fprintf(os.Stdout, "%s [%s] %s\n", level.String(), l.subsystem, msg)
+}
@@ -2,41 +2,91 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"net"
 	"net/http"
 	"os"
 	"path"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/TheCacophonyProject/bushnet-server/config"
+	"github.com/TheCacophonyProject/bushnet-server/control"
+	"github.com/TheCacophonyProject/bushnet-server/logging"
+	"github.com/TheCacophonyProject/bushnet-server/metrics"
+	"github.com/TheCacophonyProject/bushnet-server/sinks"
 	"github.com/grandcat/zeroconf"
 )
 
+var (
+	discoveryLog = logging.New("discovery")
+	httpLog      = logging.New("http")
+	ledLog       = logging.New("led")
+	downloadLog  = logging.New("download")
+	configLog    = logging.New("config")
+)
+
 const (
-	cptvFolder       = "/var/spool/cptv/downloaded"
-	avahiServiceType = "_cacophonator-management._tcp"
-	ledTriggerFile   = "/sys/class/leds/led0/trigger"
+	// backoffBase is the minimum delay before retrying a device after an
+	// error. It doubles on each consecutive failure, up to backoffMax.
+	backoffBase = 5 * time.Second
+	backoffMax  = 5 * time.Minute
 )
 
 type device struct {
 	Name    string
 	Address string
 	Port    int
+
+	// Username and Password, if set, are sent as HTTP basic auth on every
+	// request to this device, from the [devices.<name>] table in the
+	// config file.
+	Username string
+	Password string
+
+	// client is used for the recordings-list and delete calls, which are
+	// small enough to bound with an overall timeout. The recording
+	// download itself is not bounded this way, since http.Client's
+	// Timeout covers the whole body read and would cut off a large,
+	// slow transfer.
+	client *http.Client
+}
+
+// newRequest builds an HTTP request against this device, attaching basic
+// auth credentials if the device was configured with any.
+func (d device) newRequest(method, url string) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if d.Username != "" || d.Password != "" {
+		req.SetBasicAuth(d.Username, d.Password)
+	}
+	return req, nil
 }
 
 func (d device) getRecordingsList() ([]string, error) {
-	resp, err := http.Get(d.getAddr() + "/api/recordings")
+	httpLog.Debugf("GET %s/api/recordings", d.getAddr())
+	req, err := d.newRequest("GET", d.getAddr()+"/api/recordings")
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.client.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
+	metrics.HTTPStatusCodes.WithLabelValues(d.Name, "/api/recordings", strconv.Itoa(resp.StatusCode)).Inc()
 
 	if resp.StatusCode != http.StatusOK {
 		return nil, errors.New("non 200 response when getting recordings list")
@@ -49,54 +99,262 @@ func (d device) getRecordingsList() ([]string, error) {
 	return ids, nil
 }
 
-func (d device) getRecording(cptvFolder, id string) error {
-	setLedState("blinking")
-	resp, err := http.Get(d.getAddr() + "/api/recording/" + id)
+// getRecording downloads a single recording to a ".partial" file in
+// dl.CPTVFolder, resuming from wherever a previous attempt left off via an
+// HTTP Range request, and only renames it into place once verifyRecording
+// accepts it (checked against the camera's checksum where the camera
+// reports one; otherwise accepted after a clean copy). This keeps a flaky link from leaving either a truncated
+// final-name file (which consumers watching CPTVFolder would pick up as
+// whole) or a wasted from-scratch re-download every retry.
+func (d device) getRecording(id string, dl downloadOptions) error {
+	setLedState(dl.LEDTriggerFile, "blinking")
+
+	localPath := path.Join(dl.CPTVFolder, d.Name+"_"+id)
+	partialPath := localPath + ".partial"
+
+	var offset int64
+	if info, err := os.Stat(partialPath); err == nil {
+		offset = info.Size()
+	}
+
+	httpLog.Debugf("GET %s/api/recording/%s (offset %d)", d.getAddr(), id, offset)
+	start := time.Now()
+	resp, err := d.requestRecording(id, offset)
 	if err != nil {
 		return err
 	}
+
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		// The camera is saying offset itself is invalid - the partial file
+		// is already complete, or was left longer than the real file by a
+		// crash mid-write. Either way there's nothing to resume from, so
+		// drop it and fetch the whole recording again instead of retrying
+		// this same offset forever.
+		resp.Body.Close()
+		httpLog.Warnf("recording '%s' on '%s': camera rejected resume at offset %d, restarting from scratch", id, d.Name, offset)
+		os.Remove(partialPath)
+		offset = 0
+		resp, err = d.requestRecording(id, offset)
+		if err != nil {
+			return err
+		}
+	}
 	defer resp.Body.Close()
 
-	out, err := os.Create(path.Join(cptvFolder, d.Name+"_"+id))
+	// The camera may not support Range (or the partial file may have been
+	// left by an older version of this program); either way, a 200 in
+	// response to a ranged request means it's sending the whole file from
+	// byte zero, so start the partial file over.
+	if resp.StatusCode == http.StatusOK {
+		offset = 0
+	} else if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("non 200/206 response when getting recording %q", id)
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY
+	if offset > 0 {
+		flags |= os.O_APPEND
+	} else {
+		flags |= os.O_TRUNC
+	}
+	out, err := os.OpenFile(partialPath, flags, 0644)
 	if err != nil {
 		return err
 	}
-	defer out.Close()
 
-	_, err = io.Copy(out, resp.Body)
+	var src io.Reader = resp.Body
+	if dl.Limiter != nil {
+		src = dl.Limiter.throttle(resp.Body)
+	}
+
+	written, err := io.Copy(out, src)
+	closeErr := out.Close()
 	if err != nil {
+		// The partial file is left in place so the next cycle resumes
+		// from here instead of starting over.
 		return err
 	}
+	if closeErr != nil {
+		return closeErr
+	}
+	size := offset + written
+
+	if err := d.verifyRecording(id, partialPath); err != nil {
+		if errors.Is(err, errChecksumMismatch) {
+			// The bytes on disk are confirmed wrong, so don't leave them
+			// around to be "resumed" from next time.
+			os.Remove(partialPath)
+		}
+		// Any other verification error (e.g. /meta was unreachable) means
+		// the bytes on disk were never actually checked, so the partial
+		// file is left in place to resume from and re-verify next cycle.
+		return fmt.Errorf("recording %q failed verification: %w", id, err)
+	}
+
+	if err := os.Rename(partialPath, localPath); err != nil {
+		return err
+	}
+
+	metrics.RecordingsDownloaded.WithLabelValues(d.Name).Inc()
+	metrics.BytesDownloaded.WithLabelValues(d.Name).Add(float64(size))
+	metrics.DownloadDuration.WithLabelValues(d.Name).Observe(time.Since(start).Seconds())
+	metrics.RecordSuccess(d.Name)
+
+	if dl.Tracker != nil {
+		dl.Tracker.recordDownload(d.Name)
+	}
+
+	if dl.Sinks != nil {
+		// Notify blocks until every sink finishes (up to the slowest
+		// sink's own timeout), and the delete below doesn't depend on its
+		// outcome either way, so run it in the background rather than
+		// holding up this device's next recording.
+		go dl.Sinks.Notify(sinks.RecordingEvent{
+			DeviceName:  d.Name,
+			RecordingID: id,
+			Size:        size,
+			Timestamp:   time.Now(),
+			LocalPath:   localPath,
+		})
+	}
 
 	return d.deleteRecording(id)
 }
 
+// requestRecording issues a GET for id, attaching a Range header when
+// offset is positive so a previously interrupted download can resume
+// instead of starting over.
+func (d device) requestRecording(id string, offset int64) (*http.Response, error) {
+	req, err := d.newRequest("GET", d.getAddr()+"/api/recording/"+id)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	metrics.HTTPStatusCodes.WithLabelValues(d.Name, "/api/recording", strconv.Itoa(resp.StatusCode)).Inc()
+	return resp, nil
+}
+
+// recordingMeta is the response from the optional /meta endpoint. Cameras
+// that don't implement it (older firmware) answer 404, in which case
+// verifyRecording falls back to trusting a clean io.Copy.
+type recordingMeta struct {
+	SHA256 string `json:"sha256"`
+}
+
+func (d device) getRecordingMeta(id string) (*recordingMeta, error) {
+	req, err := d.newRequest("GET", d.getAddr()+"/api/recording/"+id+"/meta")
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("non 200 response when getting recording meta")
+	}
+	var meta recordingMeta
+	if err := json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// errChecksumMismatch marks a verifyRecording failure as a genuine
+// content mismatch rather than an inability to verify at all, so callers
+// can tell "the bytes on disk are wrong" (don't keep them) apart from
+// "couldn't check the bytes on disk" (keep them and retry).
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// verifyRecording checks the fully-downloaded file at path against the
+// camera-reported checksum, if the camera exposes one via /meta. If the
+// camera doesn't support /meta at all (a genuine 404), the SHA-256 is still
+// computed (for debugging via downloadLog) but there is nothing to compare
+// it against, so a clean download is accepted as-is. Any other error
+// fetching /meta - a timeout, a connection reset, a bad response - is
+// treated as a failed verification rather than "no checksum available",
+// since the camera may well have one; on the flaky links this resume
+// support targets, silently skipping verification here would mean
+// deleting the only copy of a recording without ever having confirmed it
+// downloaded intact.
+func (d device) verifyRecording(id, filePath string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	meta, err := d.getRecordingMeta(id)
+	if err != nil {
+		return fmt.Errorf("could not fetch meta for recording %q: %w", id, err)
+	}
+	if meta == nil || meta.SHA256 == "" {
+		downloadLog.Debugf("recording '%s' sha256: %s (no camera checksum to compare against)", id, sum)
+		return nil
+	}
+	if sum != meta.SHA256 {
+		return fmt.Errorf("%w: got %s, camera reported %s", errChecksumMismatch, sum, meta.SHA256)
+	}
+	return nil
+}
+
 func (d device) deleteRecording(id string) error {
-	req, err := http.NewRequest("DELETE", d.getAddr()+"/api/recording/"+id, nil)
-	client := new(http.Client)
+	httpLog.Debugf("DELETE %s/api/recording/%s", d.getAddr(), id)
+	req, err := d.newRequest("DELETE", d.getAddr()+"/api/recording/"+id)
+	if err != nil {
+		return err
+	}
 
-	resp, err := client.Do(req)
+	resp, err := d.client.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
+	metrics.HTTPStatusCodes.WithLabelValues(d.Name, "/api/recording/delete", strconv.Itoa(resp.StatusCode)).Inc()
 
 	if resp.StatusCode != http.StatusOK {
+		metrics.DeleteFailures.WithLabelValues(d.Name).Inc()
 		return errors.New("non 200 status code")
 	}
 	return nil
 }
 
-func (d device) getRecordings(cptvFolder string) error {
-	log.Printf("searching for recordings on '%s'", d.Name)
+// downloadOptions bundles the per-cycle settings a device download needs,
+// rather than growing getRecording/getRecordings' parameter lists further.
+type downloadOptions struct {
+	CPTVFolder     string
+	LEDTriggerFile string
+	Limiter        *rateLimiter
+	Sinks          *sinks.Manager
+	Tracker        *deviceTracker
+}
+
+func (d device) getRecordings(dl downloadOptions) error {
+	downloadLog.Infof("searching for recordings on '%s'", d.Name)
 	ids, err := d.getRecordingsList()
 	if err != nil {
 		return err
 	}
 	for _, id := range ids {
-		log.Printf("getting recording '%s'", id)
-		err := d.getRecording(cptvFolder, id)
-		if err != nil {
+		downloadLog.Debugf("getting recording '%s'", id)
+		if err := d.getRecording(id, dl); err != nil {
 			return err
 		}
 	}
@@ -113,30 +371,476 @@ var ledStates = map[string]string{
 	"on":       "default-on",
 }
 
+// rateLimiter configures a fixed bytes/sec budget. throttle hands back a
+// new throttledReader per call, each tracking its own window independently,
+// so the budget is per-download rather than a process-wide cap shared
+// across concurrent devices: with N devices downloading at once, aggregate
+// throughput is N times bytesPerSec, not bounded by it.
+type rateLimiter struct {
+	bytesPerSec int
+}
+
+func newRateLimiter(bytesPerSec int) *rateLimiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	return &rateLimiter{bytesPerSec: bytesPerSec}
+}
+
+func (r *rateLimiter) throttle(rd io.Reader) io.Reader {
+	return &throttledReader{r: rd, limit: r.bytesPerSec}
+}
+
+type throttledReader struct {
+	r      io.Reader
+	limit  int
+	sent   int
+	window time.Time
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if t.window.IsZero() {
+		t.window = time.Now()
+	}
+	if len(p) > t.limit {
+		p = p[:t.limit]
+	}
+	n, err := t.r.Read(p)
+	t.sent += n
+
+	if t.sent >= t.limit {
+		if elapsed := time.Since(t.window); elapsed < time.Second {
+			time.Sleep(time.Second - elapsed)
+		}
+		t.sent = 0
+		t.window = time.Now()
+	}
+	return n, err
+}
+
+// deviceState tracks the health of a single device across cycles so that a
+// flaky camera can be backed off without blocking the rest of the fleet,
+// and is also what the control API reports for a device.
+type deviceState struct {
+	device        device
+	lastSeen      time.Time
+	inFlight      bool
+	errorCount    int
+	nextAttempt   time.Time
+	lastError     string
+	downloadCount int
+	blacklisted   bool
+}
+
+// deviceTracker keeps per-device state, keyed by device name, shared between
+// the discovery goroutine, the worker pool, and the control API.
+type deviceTracker struct {
+	mu     sync.Mutex
+	states map[string]*deviceState
+}
+
+func newDeviceTracker() *deviceTracker {
+	return &deviceTracker{states: make(map[string]*deviceState)}
+}
+
+// claim records that d was just discovered and returns whether it is
+// currently eligible for a download attempt, i.e. not blacklisted, not
+// already in-flight, and past any backoff window from a previous error.
+func (t *deviceTracker) claim(d device) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := t.get(d.Name)
+	s.device = d
+	s.lastSeen = time.Now()
+
+	if s.blacklisted || s.inFlight || time.Now().Before(s.nextAttempt) {
+		return false
+	}
+	s.inFlight = true
+	return true
+}
+
+func (t *deviceTracker) succeeded(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.get(name)
+	s.inFlight = false
+	s.errorCount = 0
+	s.nextAttempt = time.Time{}
+	s.lastError = ""
+}
+
+func (t *deviceTracker) failed(name string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.get(name)
+	s.inFlight = false
+	s.errorCount++
+	s.lastError = err.Error()
+
+	backoff := backoffBase << uint(s.errorCount-1)
+	if backoff <= 0 || backoff > backoffMax {
+		backoff = backoffMax
+	}
+	s.nextAttempt = time.Now().Add(backoff)
+}
+
+// recordDownload increments name's downloaded-file count. It is called once
+// per recording, independently of whether the containing sync cycle as a
+// whole succeeds.
+func (t *deviceTracker) recordDownload(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.get(name).downloadCount++
+}
+
+// get returns the state for name, creating it if this is the first time the
+// device has been seen. Callers must hold t.mu.
+func (t *deviceTracker) get(name string) *deviceState {
+	s, ok := t.states[name]
+	if !ok {
+		s = &deviceState{}
+		t.states[name] = s
+	}
+	return s
+}
+
+// setBlacklisted marks name as blacklisted or not. A blacklisted device is
+// still tracked but never claimed for a download.
+func (t *deviceTracker) setBlacklisted(name string, blacklisted bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.get(name).blacklisted = blacklisted
+}
+
+// lookup returns the most recently seen device struct for name, for use by
+// an on-demand sync triggered through the control API.
+func (t *deviceTracker) lookup(name string) (device, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.states[name]
+	if !ok {
+		return device{}, false
+	}
+	return s.device, true
+}
+
+// snapshot returns a point-in-time copy of every tracked device's state,
+// for the control API's status endpoint.
+func (t *deviceTracker) snapshot() []control.DeviceStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	out := make([]control.DeviceStatus, 0, len(t.states))
+	for name, s := range t.states {
+		out = append(out, control.DeviceStatus{
+			Name:          name,
+			Address:       s.device.Address,
+			LastSeen:      s.lastSeen,
+			InFlight:      s.inFlight,
+			ErrorCount:    s.errorCount,
+			LastError:     s.lastError,
+			DownloadCount: s.downloadCount,
+			Blacklisted:   s.blacklisted,
+		})
+	}
+	return out
+}
+
 func main() {
-	log.SetFlags(0) // Removes default timestamp SetFlags
-	os.MkdirAll(cptvFolder, 0755)
-	setLedState("off")
-	for {
-		devices := getDevices()
-		for _, device := range devices {
-			err := device.getRecordings(cptvFolder)
-			if err != nil {
-				log.Printf("error with getting recordings from '%s': %v", device.Name, err)
-			}
+	configPath := flag.String("config", config.DefaultPath, "path to config.toml")
+	// The other flags take their defaults from the loaded file, so -config
+	// is picked out of the arguments ahead of flag.Parse.
+	args := os.Args[1:]
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		switch {
+		case strings.HasPrefix(arg, "-config="), strings.HasPrefix(arg, "--config="):
+			*configPath = arg[strings.Index(arg, "=")+1:]
+		case (arg == "-config" || arg == "--config") && i+1 < len(args):
+			*configPath = args[i+1]
 		}
-		if len(devices) > 0 {
-			setLedState("on")
+	}
+	cfg, warnings, err := config.Load(*configPath)
+	if err != nil {
+		configLog.Errorf("failed to load config: %v", err)
+		os.Exit(1)
+	}
+	for _, w := range warnings {
+		configLog.Warnf("%s", w)
+	}
+	config.RegisterFlags(flag.CommandLine, cfg)
+	flag.Parse()
+
+	if err := cfg.Validate(); err != nil {
+		configLog.Errorf("invalid config: %v", err)
+		os.Exit(1)
+	}
+
+	run(*configPath, cfg)
+}
+
+// configState holds the config a running daemon is currently acting on,
+// plus the objects derived from it that are expensive enough to build
+// (the rate limiter, the sink manager) that they should only be rebuilt
+// when the config actually changes rather than on every download. It is
+// swapped out wholesale by a SIGHUP reload, so everything in run() that
+// needs to react to a reload reads through this instead of closing over
+// the *config.Config that was live at startup.
+type configState struct {
+	v atomic.Value // holds *liveConfig
+}
+
+type liveConfig struct {
+	cfg        *config.Config
+	limiter    *rateLimiter
+	sinks      *sinks.Manager
+	httpClient *http.Client
+}
+
+func newConfigState(cfg *config.Config) *configState {
+	s := &configState{}
+	s.set(cfg)
+	return s
+}
+
+// set rebuilds every object derived from cfg and swaps it in atomically,
+// closing out the sinks it replaces (e.g. the Kafka sink's writer and its
+// connections) so a long-running daemon doesn't leak one set of sink
+// resources per SIGHUP.
+func (s *configState) set(cfg *config.Config) {
+	next := &liveConfig{
+		cfg:        cfg,
+		limiter:    newRateLimiter(cfg.RateLimit),
+		sinks:      newSinkManager(cfg.Sinks),
+		httpClient: &http.Client{Timeout: cfg.HTTPTimeout},
+	}
+	if prev, ok := s.v.Swap(next).(*liveConfig); ok {
+		prev.sinks.Close()
+	}
+}
+
+func (s *configState) get() *liveConfig {
+	return s.v.Load().(*liveConfig)
+}
+
+// concurrencyGate bounds how many devices download at once, re-reading its
+// limit function on every wakeup. That makes a SIGHUP-driven change to
+// --concurrency take effect on the next slot, instead of only after a
+// restart.
+type concurrencyGate struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	limit  func() int
+	active int
+}
+
+func newConcurrencyGate(limit func() int) *concurrencyGate {
+	g := &concurrencyGate{limit: limit}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+// acquire blocks until fewer than the current limit are active, then
+// reserves a slot. Callers must call release when done.
+func (g *concurrencyGate) acquire() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for g.active >= g.limit() {
+		g.cond.Wait()
+	}
+	g.active++
+}
+
+func (g *concurrencyGate) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.active--
+	g.cond.Broadcast()
+}
+
+// wake re-evaluates limit for any goroutines already blocked in acquire,
+// e.g. after a SIGHUP raises --concurrency.
+func (g *concurrencyGate) wake() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.cond.Broadcast()
+}
+
+// newSinkManager builds a sinks.Manager from the sinks enabled in the
+// config file. Sinks that fail to construct (e.g. a bad S3 endpoint) are
+// logged and skipped rather than aborting startup.
+func newSinkManager(cfg config.SinksConfig) *sinks.Manager {
+	var enabled []sinks.Sink
+
+	if cfg.Webhook.Enabled {
+		enabled = append(enabled, sinks.NewWebhookSink(cfg.Webhook.URL))
+	}
+	if cfg.Kafka.Enabled {
+		enabled = append(enabled, sinks.NewKafkaSink(cfg.Kafka.Brokers, cfg.Kafka.Topic))
+	}
+	if cfg.S3.Enabled {
+		s3Sink, err := sinks.NewS3Sink(cfg.S3.Endpoint, cfg.S3.Bucket, cfg.S3.AccessKey, cfg.S3.SecretKey, cfg.S3.UseSSL, cfg.S3.DeleteLocal)
+		if err != nil {
+			downloadLog.Errorf("failed to set up s3 sink: %v", err)
 		} else {
-			setLedState("off")
+			enabled = append(enabled, s3Sink)
+		}
+	}
+
+	return sinks.NewManager(enabled...)
+}
+
+// run starts the daemon against the config loaded from configPath, and
+// keeps it running against live updates to that config delivered by a
+// SIGHUP (see configState and concurrencyGate). ControlAddr and
+// MetricsAddr are only read once, at startup: changing which address (or
+// whether) those servers listen on requires a restart, since that means
+// tearing down and rebinding a listener rather than just picking up a new
+// value on the next use.
+func run(configPath string, cfg *config.Config) {
+	state := newConfigState(cfg)
+	gate := newConcurrencyGate(func() int { return state.get().cfg.Concurrency })
+
+	config.WatchReload(configPath, func(newCfg *config.Config, warnings []string, err error) {
+		if err != nil {
+			configLog.Errorf("failed to reload config on SIGHUP: %v", err)
+			return
+		}
+		for _, w := range warnings {
+			configLog.Warnf("%s", w)
+		}
+		if err := newCfg.Validate(); err != nil {
+			configLog.Errorf("ignoring invalid config reloaded on SIGHUP: %v", err)
+			return
+		}
+		state.set(newCfg)
+		gate.wake()
+		configLog.Infof("config reloaded")
+	})
+
+	setLedState(cfg.LEDTriggerFile, "off")
+
+	tracker := newDeviceTracker()
+
+	var active int
+	var activeMu sync.Mutex
+
+	dispatch := func(d device) {
+		go func() {
+			gate.acquire()
+			defer gate.release()
+
+			activeMu.Lock()
+			active++
+			setLedState(state.get().cfg.LEDTriggerFile, "on")
+			activeMu.Unlock()
+
+			live := state.get()
+			dl := downloadOptions{
+				CPTVFolder:     live.cfg.CPTVFolder,
+				LEDTriggerFile: live.cfg.LEDTriggerFile,
+				Limiter:        live.limiter,
+				Sinks:          live.sinks,
+				Tracker:        tracker,
+			}
+			if err := d.getRecordings(dl); err != nil {
+				downloadLog.Errorf("error with getting recordings from '%s': %v", d.Name, err)
+				tracker.failed(d.Name, err)
+			} else {
+				tracker.succeeded(d.Name)
+			}
+
+			activeMu.Lock()
+			active--
+			if active == 0 {
+				setLedState(state.get().cfg.LEDTriggerFile, "off")
+			}
+			activeMu.Unlock()
+		}()
+	}
+
+	onDevice := func(d device) {
+		if tracker.claim(d) {
+			dispatch(d)
 		}
 	}
+
+	if cfg.ControlAddr != "" {
+		go serveControlAPI(state, tracker, dispatch)
+	}
+
+	if cfg.MetricsAddr != "" {
+		go serveMetrics(cfg.MetricsAddr)
+	}
+
+	// Browse in cfg.PollInterval-long windows rather than a single
+	// unbounded call: mDNS entries can go stale, so periodically
+	// restarting the browse keeps the device list fresh while still
+	// reacting to each entry as soon as it arrives within a window.
+	for {
+		live := state.get()
+		ctx, cancel := context.WithTimeout(context.Background(), live.cfg.PollInterval)
+		discoverDevices(ctx, live.cfg, live.httpClient, onDevice)
+		cancel()
+	}
 }
 
-func setLedState(s string) {
+// serveControlAPI starts the control API HTTP server, blocking until it
+// exits (which should only happen on a listener error). It reads state
+// live on every request so it reflects whatever config a SIGHUP last
+// reloaded, not just the config at the time this was called.
+func serveControlAPI(state *configState, tracker *deviceTracker, dispatch func(device)) {
+	srv := &control.Server{
+		Status: tracker.snapshot,
+		Sync: func(name string) error {
+			d, ok := tracker.lookup(name)
+			if !ok {
+				return fmt.Errorf("%w: %q", control.ErrNotFound, name)
+			}
+			if !tracker.claim(d) {
+				return fmt.Errorf("device %q is blacklisted, already syncing, or backing off after an error", name)
+			}
+			dispatch(d)
+			return nil
+		},
+		SetBlacklisted: func(name string, blacklisted bool) error {
+			if _, ok := tracker.lookup(name); !ok {
+				return fmt.Errorf("%w: %q", control.ErrNotFound, name)
+			}
+			tracker.setBlacklisted(name, blacklisted)
+			return nil
+		},
+		SetLED: func(ledState string) error {
+			if _, ok := ledStates[ledState]; !ok {
+				return fmt.Errorf("unknown LED state %q", ledState)
+			}
+			setLedState(state.get().cfg.LEDTriggerFile, ledState)
+			return nil
+		},
+	}
+
+	addr := state.get().cfg.ControlAddr
+	discoveryLog.Infof("serving control API on %s", addr)
+	if err := http.ListenAndServe(addr, srv.Handler()); err != nil {
+		discoveryLog.Errorf("control API server stopped: %v", err)
+	}
+}
+
+func serveMetrics(addr string) {
+	discoveryLog.Infof("serving metrics on %s", addr)
+	if err := metrics.Serve(addr); err != nil {
+		discoveryLog.Errorf("metrics server stopped: %v", err)
+	}
+}
+
+func setLedState(ledTriggerFile, s string) {
 	newState := ledStates[s]
 	if newState == "" {
-		log.Printf("unknown LED state '%s'", s)
+		ledLog.Warnf("unknown LED state '%s'", s)
 		return
 	}
 
@@ -154,39 +858,47 @@ func setLedState(s string) {
 
 	err = ioutil.WriteFile(ledTriggerFile, []byte(newState), 0644)
 	if err != nil {
-		log.Println(err)
+		ledLog.Errorf("%v", err)
 	}
 }
 
-func getDevices() []device {
-	var devices []device
-	log.Println("starting search for devices...")
+// discoverDevices browses for devices for as long as ctx is alive, invoking
+// onDevice for each zeroconf entry as it arrives. This replaces the old
+// browse-for-10-seconds-then-process-the-batch cycle with an event-driven
+// model so new devices are picked up immediately instead of at the start of
+// the next poll.
+func discoverDevices(ctx context.Context, cfg *config.Config, httpClient *http.Client, onDevice func(device)) {
+	discoveryLog.Infof("starting search for devices...")
 	resolver, err := zeroconf.NewResolver(nil)
 	if err != nil {
-		log.Fatalln("Failed to initialize resolver: %v", err)
-		return nil
+		discoveryLog.Errorf("failed to initialize resolver: %v", err)
+		os.Exit(1)
 	}
 
 	entries := make(chan *zeroconf.ServiceEntry)
 	go func(results <-chan *zeroconf.ServiceEntry) {
 		for entry := range results {
-			r := device{
-				Name:    entry.HostName[:len(entry.HostName)-7],
-				Address: entry.AddrIPv4[0].String(),
-				Port:    entry.Port,
+			if len(entry.AddrIPv4) == 0 {
+				continue
 			}
-			devices = append(devices, r)
+			metrics.DevicesDiscovered.Inc()
+			name := entry.HostName[:len(entry.HostName)-7]
+			creds := cfg.Devices[name]
+			onDevice(device{
+				Name:     name,
+				Address:  entry.AddrIPv4[0].String(),
+				Port:     entry.Port,
+				Username: creds.Username,
+				Password: creds.Password,
+				client:   httpClient,
+			})
 		}
 	}(entries)
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-	defer cancel()
-	err = resolver.Browse(ctx, avahiServiceType, "local.", entries)
-	if err != nil {
-		log.Fatalln("Failed to browse: %v", err)
+	if err := resolver.Browse(ctx, cfg.ServiceType, "local.", entries); err != nil {
+		discoveryLog.Errorf("failed to browse: %v", err)
+		os.Exit(1)
 	}
 
 	<-ctx.Done()
-	log.Printf("found %d devices", len(devices))
-	return devices
 }
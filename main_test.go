@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestNewRateLimiterDisabledForNonPositiveBudget(t *testing.T) {
+	if r := newRateLimiter(0); r != nil {
+		t.Errorf("newRateLimiter(0) = %v, want nil", r)
+	}
+	if r := newRateLimiter(-1); r != nil {
+		t.Errorf("newRateLimiter(-1) = %v, want nil", r)
+	}
+}
+
+func TestThrottledReaderSleepsOncePerWindow(t *testing.T) {
+	limiter := newRateLimiter(10)
+	src := bytes.NewReader(make([]byte, 25))
+	r := limiter.throttle(src)
+
+	start := time.Now()
+	n, err := io.Copy(io.Discard, r)
+	elapsed := time.Since(start)
+
+	if err != nil {
+		t.Fatalf("io.Copy: %v", err)
+	}
+	if n != 25 {
+		t.Fatalf("copied %d bytes, want 25", n)
+	}
+	// 25 bytes at a 10 bytes/sec budget crosses the limit twice, so the
+	// reader should have slept roughly 2 seconds total.
+	if elapsed < 2*time.Second {
+		t.Errorf("elapsed = %v, want at least 2s for a 25-byte read at 10 bytes/sec", elapsed)
+	}
+}
+
+func TestThrottledReaderUnderBudgetDoesNotSleep(t *testing.T) {
+	limiter := newRateLimiter(1000)
+	src := bytes.NewReader(make([]byte, 10))
+	r := limiter.throttle(src)
+
+	start := time.Now()
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		t.Fatalf("io.Copy: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("elapsed = %v, want near-instant for a read under budget", elapsed)
+	}
+}
+
+func TestDeviceTrackerClaimSucceededResetsBackoff(t *testing.T) {
+	tracker := newDeviceTracker()
+	d := device{Name: "cam1"}
+
+	if !tracker.claim(d) {
+		t.Fatal("claim() = false on first sight of a device, want true")
+	}
+	if tracker.claim(d) {
+		t.Error("claim() = true while already in-flight, want false")
+	}
+
+	tracker.failed(d.Name, errors.New("boom"))
+	if tracker.claim(d) {
+		t.Fatal("claim() = true during backoff after a failure, want false")
+	}
+
+	tracker.succeeded(d.Name)
+	if !tracker.claim(d) {
+		t.Error("claim() = false after succeeded(), want true")
+	}
+}
+
+func TestDeviceTrackerFailedBacksOffExponentially(t *testing.T) {
+	tracker := newDeviceTracker()
+	d := device{Name: "cam1"}
+
+	tracker.claim(d)
+	tracker.failed(d.Name, errors.New("boom"))
+
+	if tracker.claim(d) {
+		t.Fatal("claim() = true immediately after a failure, want false until backoff elapses")
+	}
+
+	s := tracker.get(d.Name)
+	if s.errorCount != 1 {
+		t.Errorf("errorCount = %d, want 1", s.errorCount)
+	}
+	firstBackoff := time.Until(s.nextAttempt)
+
+	tracker.failed(d.Name, errors.New("boom again"))
+	if s.errorCount != 2 {
+		t.Errorf("errorCount = %d, want 2", s.errorCount)
+	}
+	secondBackoff := time.Until(s.nextAttempt)
+	if secondBackoff <= firstBackoff {
+		t.Errorf("second backoff (%v) did not grow past the first (%v)", secondBackoff, firstBackoff)
+	}
+}
+
+func TestDeviceTrackerFailedCapsBackoffAtMax(t *testing.T) {
+	tracker := newDeviceTracker()
+	d := device{Name: "cam1"}
+	tracker.claim(d)
+
+	for i := 0; i < 10; i++ {
+		tracker.failed(d.Name, errors.New("boom"))
+	}
+
+	s := tracker.get(d.Name)
+	if backoff := time.Until(s.nextAttempt); backoff > backoffMax {
+		t.Errorf("backoff = %v, want capped at %v", backoff, backoffMax)
+	}
+}
+
+func TestDeviceTrackerBlacklistedDeviceNotClaimable(t *testing.T) {
+	tracker := newDeviceTracker()
+	d := device{Name: "cam1"}
+
+	tracker.setBlacklisted(d.Name, true)
+	if tracker.claim(d) {
+		t.Error("claim() = true for a blacklisted device, want false")
+	}
+
+	tracker.setBlacklisted(d.Name, false)
+	if !tracker.claim(d) {
+		t.Error("claim() = false after un-blacklisting, want true")
+	}
+}
@@ -0,0 +1,73 @@
+// Package metrics exposes Prometheus counters and histograms for
+// discovery, downloads, and errors, so field deployments can alert on
+// cameras that have gone silent without scraping log files.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	DevicesDiscovered = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "bushnet",
+		Name:      "devices_discovered_total",
+		Help:      "Number of zeroconf device entries seen across all discovery cycles.",
+	})
+
+	RecordingsDownloaded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "bushnet",
+		Name:      "recordings_downloaded_total",
+		Help:      "Number of recordings successfully downloaded, by device.",
+	}, []string{"device"})
+
+	BytesDownloaded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "bushnet",
+		Name:      "bytes_downloaded_total",
+		Help:      "Total bytes downloaded from recordings, by device.",
+	}, []string{"device"})
+
+	DownloadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "bushnet",
+		Name:      "download_duration_seconds",
+		Help:      "Time taken to download a single recording, by device.",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 12),
+	}, []string{"device"})
+
+	DeleteFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "bushnet",
+		Name:      "delete_failures_total",
+		Help:      "Number of failed attempts to delete a recording from a device, by device.",
+	}, []string{"device"})
+
+	HTTPStatusCodes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "bushnet",
+		Name:      "http_status_codes_total",
+		Help:      "HTTP status codes seen from devices, by device, request path, and status code.",
+	}, []string{"device", "path", "status"})
+
+	LastSuccessTimestamp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "bushnet",
+		Name:      "device_last_success_timestamp_seconds",
+		Help:      "Unix timestamp of the last successful contact with a device.",
+	}, []string{"device"})
+)
+
+// RecordSuccess marks device as successfully contacted just now. Combined
+// with time(), this lets an alert fire on
+// "bushnet_device_last_success_timestamp_seconds" going stale.
+func RecordSuccess(device string) {
+	LastSuccessTimestamp.WithLabelValues(device).Set(float64(time.Now().Unix()))
+}
+
+// Serve starts the /metrics endpoint on addr, blocking until the listener
+// fails.
+func Serve(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	return http.ListenAndServe(addr, mux)
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// testDevice starts an httptest server running handler and returns a
+// device pointed at it, ready to pass to getRecording.
+func testDevice(t *testing.T, handler http.Handler) device {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, portStr, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return device{
+		Name:    "cam1",
+		Address: host,
+		Port:    port,
+		client:  server.Client(),
+	}
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestGetRecordingFullDownloadAndVerify(t *testing.T) {
+	body := []byte("cptv-file-contents")
+
+	// The delete call is DELETE /api/recording/rec1, the same path as the
+	// GET, so route on method within a single handler.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/recording/rec1", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.Write(body)
+	})
+	mux.HandleFunc("/api/recording/rec1/meta", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"sha256":%q}`, sha256Hex(body))
+	})
+
+	d := testDevice(t, mux)
+	dir := t.TempDir()
+
+	if err := d.getRecording("rec1", downloadOptions{CPTVFolder: dir}); err != nil {
+		t.Fatalf("getRecording: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "cam1_rec1"))
+	if err != nil {
+		t.Fatalf("reading downloaded file: %v", err)
+	}
+	if string(got) != string(body) {
+		t.Errorf("downloaded contents = %q, want %q", got, body)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "cam1_rec1.partial")); !os.IsNotExist(err) {
+		t.Errorf("expected .partial file to be gone after a verified download, stat err = %v", err)
+	}
+}
+
+func TestGetRecordingResumesFromPartialFile(t *testing.T) {
+	full := []byte("0123456789")
+	already := full[:4]
+	rest := full[4:]
+
+	var gotRange string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/recording/rec1", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		gotRange = r.Header.Get("Range")
+		if gotRange != "" {
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(rest)
+			return
+		}
+		w.Write(full)
+	})
+	mux.HandleFunc("/api/recording/rec1/meta", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"sha256":%q}`, sha256Hex(full))
+	})
+
+	d := testDevice(t, mux)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "cam1_rec1.partial"), already, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.getRecording("rec1", downloadOptions{CPTVFolder: dir}); err != nil {
+		t.Fatalf("getRecording: %v", err)
+	}
+	if gotRange != "bytes=4-" {
+		t.Errorf("Range header = %q, want %q", gotRange, "bytes=4-")
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "cam1_rec1"))
+	if err != nil {
+		t.Fatalf("reading downloaded file: %v", err)
+	}
+	if string(got) != string(full) {
+		t.Errorf("downloaded contents = %q, want %q", got, full)
+	}
+}
+
+func TestGetRecordingRetriesFromScratchOn416(t *testing.T) {
+	full := []byte("the-whole-recording")
+
+	var rangeRequests int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/recording/rec1", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if r.Header.Get("Range") != "" {
+			rangeRequests++
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		w.Write(full)
+	})
+	mux.HandleFunc("/api/recording/rec1/meta", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"sha256":%q}`, sha256Hex(full))
+	})
+
+	d := testDevice(t, mux)
+	dir := t.TempDir()
+	// A stale partial file the camera no longer recognizes as resumable.
+	if err := os.WriteFile(filepath.Join(dir, "cam1_rec1.partial"), []byte("stale-garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.getRecording("rec1", downloadOptions{CPTVFolder: dir}); err != nil {
+		t.Fatalf("getRecording: %v", err)
+	}
+	if rangeRequests != 1 {
+		t.Errorf("expected exactly one ranged request before falling back, got %d", rangeRequests)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "cam1_rec1"))
+	if err != nil {
+		t.Fatalf("reading downloaded file: %v", err)
+	}
+	if string(got) != string(full) {
+		t.Errorf("downloaded contents = %q, want %q", got, full)
+	}
+}
+
+func TestGetRecordingChecksumMismatchLeavesNothingBehind(t *testing.T) {
+	body := []byte("tampered-in-transit")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/recording/rec1", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	})
+	mux.HandleFunc("/api/recording/rec1/meta", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"sha256":"not-the-real-checksum"}`)
+	})
+
+	d := testDevice(t, mux)
+	dir := t.TempDir()
+
+	err := d.getRecording("rec1", downloadOptions{CPTVFolder: dir})
+	if err == nil {
+		t.Fatal("getRecording: got nil error, want a checksum mismatch error")
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "cam1_rec1")); !os.IsNotExist(err) {
+		t.Errorf("final file should not exist after a checksum mismatch, stat err = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "cam1_rec1.partial")); !os.IsNotExist(err) {
+		t.Errorf(".partial file should be removed after a checksum mismatch, stat err = %v", err)
+	}
+}
+
+func TestGetRecordingMetaFetchErrorFailsDownload(t *testing.T) {
+	body := []byte("downloaded-fine-but-unverifiable")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/recording/rec1", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	})
+	mux.HandleFunc("/api/recording/rec1/meta", func(w http.ResponseWriter, r *http.Request) {
+		// A camera that does support /meta, but is erroring rather than
+		// cleanly reporting "not implemented" (404).
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	d := testDevice(t, mux)
+	dir := t.TempDir()
+
+	err := d.getRecording("rec1", downloadOptions{CPTVFolder: dir})
+	if err == nil {
+		t.Fatal("getRecording: got nil error, want failure when /meta can't be fetched")
+	}
+
+	if _, statErr := os.Stat(filepath.Join(dir, "cam1_rec1")); !os.IsNotExist(statErr) {
+		t.Errorf("final file should not exist when verification couldn't run, stat err = %v", statErr)
+	}
+	if _, statErr := os.Stat(filepath.Join(dir, "cam1_rec1.partial")); statErr != nil {
+		t.Errorf(".partial file should be kept for the next retry, stat err = %v", statErr)
+	}
+}
+
+func TestGetRecordingNoMetaEndpointAcceptsCleanDownload(t *testing.T) {
+	body := []byte("older-firmware-no-meta-endpoint")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/recording/rec1", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.Write(body)
+	})
+	mux.HandleFunc("/api/recording/rec1/meta", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	d := testDevice(t, mux)
+	dir := t.TempDir()
+
+	if err := d.getRecording("rec1", downloadOptions{CPTVFolder: dir}); err != nil {
+		t.Fatalf("getRecording: %v", err)
+	}
+}
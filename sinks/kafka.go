@@ -0,0 +1,53 @@
+package sinks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+)
+
+// KafkaSink publishes each recording event as a JSON message to a Kafka
+// topic.
+type KafkaSink struct {
+	Topic  string
+	writer *kafka.Writer
+}
+
+// NewKafkaSink returns a KafkaSink that publishes to topic via brokers.
+func NewKafkaSink(brokers []string, topic string) *KafkaSink {
+	return &KafkaSink{
+		Topic: topic,
+		writer: &kafka.Writer{
+			Addr:     kafka.TCP(brokers...),
+			Topic:    topic,
+			Balancer: &kafka.LeastBytes{},
+		},
+	}
+}
+
+func (s *KafkaSink) Name() string { return "kafka" }
+
+func (s *KafkaSink) Notify(event RecordingEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("kafka: failed to marshal event: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := s.writer.WriteMessages(ctx, kafka.Message{
+		Key:   []byte(event.DeviceName),
+		Value: body,
+	}); err != nil {
+		return fmt.Errorf("kafka: failed to publish to topic %q: %w", s.Topic, err)
+	}
+	return nil
+}
+
+func (s *KafkaSink) Close() error {
+	return s.writer.Close()
+}
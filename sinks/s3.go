@@ -0,0 +1,57 @@
+package sinks
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Sink mirrors a downloaded recording to an S3-compatible object store
+// (AWS S3, MinIO, ...), and optionally deletes the local copy once the
+// upload has been confirmed.
+type S3Sink struct {
+	Bucket      string
+	DeleteLocal bool
+
+	client *minio.Client
+}
+
+// NewS3Sink returns an S3Sink that uploads to bucket via the object store
+// at endpoint.
+func NewS3Sink(endpoint, bucket, accessKey, secretKey string, useSSL, deleteLocal bool) (*S3Sink, error) {
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
+		Secure: useSSL,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("s3: failed to create client for %s: %w", endpoint, err)
+	}
+	return &S3Sink{Bucket: bucket, DeleteLocal: deleteLocal, client: client}, nil
+}
+
+func (s *S3Sink) Name() string { return "s3" }
+
+func (s *S3Sink) Notify(event RecordingEvent) error {
+	if event.LocalPath == "" {
+		return fmt.Errorf("s3: event for '%s_%s' has no local path to upload", event.DeviceName, event.RecordingID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	objectName := fmt.Sprintf("%s/%s", event.DeviceName, event.RecordingID)
+	if _, err := s.client.FPutObject(ctx, s.Bucket, objectName, event.LocalPath, minio.PutObjectOptions{}); err != nil {
+		return fmt.Errorf("s3: failed to upload %s to %s/%s: %w", event.LocalPath, s.Bucket, objectName, err)
+	}
+
+	if s.DeleteLocal {
+		if err := os.Remove(event.LocalPath); err != nil {
+			return fmt.Errorf("s3: uploaded %s but failed to delete local copy: %w", event.LocalPath, err)
+		}
+	}
+	return nil
+}
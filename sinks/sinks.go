@@ -0,0 +1,82 @@
+// Package sinks fans a downloaded-recording event out to zero or more
+// notification/upload destinations: a webhook, a Kafka topic (NATS is not
+// supported), and/or an S3-compatible object store. Sinks run concurrently;
+// a failing sink never stops the others and its error never reaches the
+// caller, though Notify does wait for every sink to finish.
+package sinks
+
+import (
+	"sync"
+	"time"
+
+	"github.com/TheCacophonyProject/bushnet-server/logging"
+)
+
+var log = logging.New("sinks")
+
+// RecordingEvent describes a single recording that has just been
+// downloaded from a device.
+type RecordingEvent struct {
+	DeviceName  string    `json:"deviceName"`
+	RecordingID string    `json:"recordingId"`
+	Size        int64     `json:"size"`
+	Timestamp   time.Time `json:"timestamp"`
+
+	// LocalPath is the downloaded file's location in cptvFolder, for
+	// sinks that need to read it (e.g. the S3 uploader).
+	LocalPath string `json:"-"`
+}
+
+// Sink is a single notification or upload destination.
+type Sink interface {
+	// Name identifies the sink in logs.
+	Name() string
+	// Notify delivers event to the sink. It must not modify
+	// event.LocalPath; only the S3 sink with DeleteLocal set removes it,
+	// after uploading it.
+	Notify(event RecordingEvent) error
+}
+
+// Manager holds the configured sinks and fans events out to all of them.
+type Manager struct {
+	sinks []Sink
+}
+
+// NewManager returns a Manager that notifies every one of sinks for each
+// event. A nil or empty slice is valid and makes Notify a no-op.
+func NewManager(sinks ...Sink) *Manager {
+	return &Manager{sinks: sinks}
+}
+
+// Close releases resources held by any sink that needs it, such as the
+// Kafka sink's writer and its connections. Call it once a Manager has been
+// replaced (e.g. by a config reload) and is no longer handed new events.
+func (m *Manager) Close() {
+	for _, s := range m.sinks {
+		closer, ok := s.(interface{ Close() error })
+		if !ok {
+			continue
+		}
+		if err := closer.Close(); err != nil {
+			log.Errorf("failed to close sink %q: %v", s.Name(), err)
+		}
+	}
+}
+
+// Notify runs every configured sink concurrently and waits for them all to
+// finish. A sink that returns an error only has that error logged; it never
+// stops the other sinks from running or propagates back to the caller, so a
+// broken webhook can't hold up the camera-side delete.
+func (m *Manager) Notify(event RecordingEvent) {
+	var wg sync.WaitGroup
+	for _, s := range m.sinks {
+		wg.Add(1)
+		go func(s Sink) {
+			defer wg.Done()
+			if err := s.Notify(event); err != nil {
+				log.Errorf("sink %q failed for recording '%s_%s': %v", s.Name(), event.DeviceName, event.RecordingID, err)
+			}
+		}(s)
+	}
+	wg.Wait()
+}
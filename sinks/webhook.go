@@ -0,0 +1,45 @@
+package sinks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// WebhookSink POSTs a JSON payload describing the recording to a configured
+// URL.
+type WebhookSink struct {
+	URL    string
+	client *http.Client
+}
+
+// NewWebhookSink returns a WebhookSink that posts to url with a bounded
+// request timeout.
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{
+		URL:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *WebhookSink) Name() string { return "webhook" }
+
+func (s *WebhookSink) Notify(event RecordingEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("webhook: failed to marshal event: %w", err)
+	}
+
+	resp, err := s.client.Post(s.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("webhook: failed to POST %s: %w", s.URL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook: %s returned status %d", s.URL, resp.StatusCode)
+	}
+	return nil
+}